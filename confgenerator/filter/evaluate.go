@@ -0,0 +1,164 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/filter/internal/ast"
+)
+
+// MemberValue looks up m's field path in record, honoring the same nested
+// field-path semantics (jsonPayload.foo.bar) used when lowering a filter to
+// Fluent Bit or Lua. It returns ok=false if any path segment is missing or
+// record doesn't have maps all the way down.
+func (m *Member) MemberValue(record map[string]interface{}) (interface{}, bool) {
+	var cur interface{} = record
+	for _, part := range []string(m.Target) {
+		asMap, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = asMap[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Evaluate walks f's AST directly against record, without generating any
+// Fluent Bit components or Lua source. It lets callers that don't run
+// inside Fluent Bit -- the health-check subsystem, unit tests, and any
+// future OTel-based pipeline -- reuse the same filter expressions that
+// Components/NewLuaFilter lower for the logging pipeline.
+func (f *Filter) Evaluate(record map[string]interface{}) (bool, error) {
+	return evaluateExpression(f.expr, record)
+}
+
+func evaluateExpression(e ast.Expression, record map[string]interface{}) (bool, error) {
+	switch e := e.(type) {
+	case ast.Disjunction:
+		for _, term := range e {
+			ok, err := evaluateExpression(term, record)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ast.Conjunction:
+		for _, term := range e {
+			ok, err := evaluateExpression(term, record)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	case ast.Negation:
+		ok, err := evaluateExpression(e.Expr, record)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	case ast.Restriction:
+		return evaluateRestriction(e, record)
+	case fieldMatch:
+		value, ok := (&Member{e.field}).MemberValue(record)
+		if !ok {
+			return false, nil
+		}
+		return e.Evaluate(fmt.Sprintf("%v", value)), nil
+	default:
+		return false, fmt.Errorf("filter: Evaluate does not support expression type %T", e)
+	}
+}
+
+func evaluateRestriction(r ast.Restriction, record map[string]interface{}) (bool, error) {
+	m := &Member{r.LHS}
+	value, present := m.MemberValue(record)
+	if r.Operator == "GLOBAL" {
+		// A bare field reference, e.g. the "severity" in NewMember("severity"):
+		// the restriction matches if the field is present at all.
+		return present, nil
+	}
+	if !present {
+		return false, nil
+	}
+	lhs := fmt.Sprintf("%v", value)
+	rhs := fmt.Sprintf("%v", r.RHS)
+	switch r.Operator {
+	case "=":
+		return compareEqual(lhs, rhs), nil
+	case "!=":
+		return !compareEqual(lhs, rhs), nil
+	case ":":
+		return strings.Contains(lhs, rhs), nil
+	case "=~", "!~":
+		re, err := regexp.Compile(rhs)
+		if err != nil {
+			return false, fmt.Errorf("filter: invalid regex %q: %w", rhs, err)
+		}
+		matched := re.MatchString(lhs)
+		if r.Operator == "!~" {
+			matched = !matched
+		}
+		return matched, nil
+	case "<", ">", "<=", ">=":
+		lhsNum, lok := toFloat(lhs)
+		rhsNum, rok := toFloat(rhs)
+		if !lok || !rok {
+			return false, fmt.Errorf("filter: %s requires numeric operands, got %q and %q", r.Operator, lhs, rhs)
+		}
+		switch r.Operator {
+		case "<":
+			return lhsNum < rhsNum, nil
+		case ">":
+			return lhsNum > rhsNum, nil
+		case "<=":
+			return lhsNum <= rhsNum, nil
+		default: // ">="
+			return lhsNum >= rhsNum, nil
+		}
+	default:
+		return false, fmt.Errorf("filter: Evaluate does not support operator %q", r.Operator)
+	}
+}
+
+// compareEqual implements Cloud Logging's `=` semantics: an exact match for
+// literal patterns, and a glob match (`*`, `?`, `[...]`) otherwise.
+func compareEqual(value, pattern string) bool {
+	if !strings.ContainsAny(pattern, globMetacharacters) {
+		return value == pattern
+	}
+	re, err := regexp.Compile("^(" + globToRegex(pattern) + ")$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+func toFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
@@ -0,0 +1,374 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/fluentbit"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		record map[string]interface{}
+		want   bool
+	}{
+		{
+			name:   "equality match",
+			filter: `severity = "ERROR"`,
+			record: map[string]interface{}{"severity": "ERROR"},
+			want:   true,
+		},
+		{
+			name:   "equality mismatch",
+			filter: `severity = "ERROR"`,
+			record: map[string]interface{}{"severity": "INFO"},
+			want:   false,
+		},
+		{
+			name:   "not equal",
+			filter: `severity != "ERROR"`,
+			record: map[string]interface{}{"severity": "INFO"},
+			want:   true,
+		},
+		{
+			name:   "glob",
+			filter: `jsonPayload.container.name = "foo*"`,
+			record: map[string]interface{}{"jsonPayload": map[string]interface{}{"container": map[string]interface{}{"name": "foobar"}}},
+			want:   true,
+		},
+		{
+			name:   "missing field",
+			filter: `jsonPayload.container.name = "foobar"`,
+			record: map[string]interface{}{"jsonPayload": map[string]interface{}{}},
+			want:   false,
+		},
+		{
+			name:   "regex match",
+			filter: `severity =~ "^ERR.*"`,
+			record: map[string]interface{}{"severity": "ERROR"},
+			want:   true,
+		},
+		{
+			name:   "regex no match",
+			filter: `severity !~ "^ERR.*"`,
+			record: map[string]interface{}{"severity": "INFO"},
+			want:   true,
+		},
+		{
+			name:   "numeric comparison",
+			filter: `httpRequest.status > 400`,
+			record: map[string]interface{}{"httpRequest": map[string]interface{}{"status": "503"}},
+			want:   true,
+		},
+		{
+			name:   "has field",
+			filter: `severity`,
+			record: map[string]interface{}{"severity": "ERROR"},
+			want:   true,
+		},
+		{
+			name:   "has field missing",
+			filter: `severity`,
+			record: map[string]interface{}{},
+			want:   false,
+		},
+		{
+			name:   "disjunction",
+			filter: `severity = "ERROR" OR severity = "WARNING"`,
+			record: map[string]interface{}{"severity": "WARNING"},
+			want:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFilter(tc.filter)
+			if err != nil {
+				t.Fatalf("NewFilter(%q) = %v", tc.filter, err)
+			}
+			got, err := f.Evaluate(tc.record)
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Evaluate(%v) = %v, want %v", tc.record, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestEvaluateAgreesWithOptimize asserts that Optimize (which rewrites the
+// AST for the Fluent Bit pipeline) doesn't change what Evaluate reports for
+// the same record -- the two code paths must never disagree about a match.
+// This covers both a single-segment field (severity) and the nested
+// jsonPayload.container.name path the request calls out explicitly, since
+// fieldMatch's field round-trips through an ast.Target rather than the flat
+// field name.
+func TestEvaluateAgreesWithOptimize(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		records []map[string]interface{}
+	}{
+		{
+			name:   "single-segment field",
+			filter: `severity = "ERROR" OR severity = "WARNING"`,
+			records: []map[string]interface{}{
+				{"severity": "ERROR"},
+				{"severity": "WARNING"},
+				{"severity": "INFO"},
+			},
+		},
+		{
+			name:   "nested glob field",
+			filter: `jsonPayload.container.name = "foo*" OR jsonPayload.container.name = "bar*"`,
+			records: []map[string]interface{}{
+				{"jsonPayload": map[string]interface{}{"container": map[string]interface{}{"name": "foobar"}}},
+				{"jsonPayload": map[string]interface{}{"container": map[string]interface{}{"name": "barbaz"}}},
+				{"jsonPayload": map[string]interface{}{"container": map[string]interface{}{"name": "quux"}}},
+				{"jsonPayload": map[string]interface{}{}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, record := range tc.records {
+				unoptimized, err := NewFilter(tc.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				optimized, err := NewFilter(tc.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				optimized.Optimize()
+
+				want, err := unoptimized.Evaluate(record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := optimized.Evaluate(record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("Evaluate(%v) after Optimize() = %v, want %v", record, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestRenderedOutputsAgreeWithEvaluate renders the actual artifacts the
+// other two backends ship -- the Fluent Bit Key_value_matches condition
+// Components emits, and the string.match/rex.new pattern NewLuaFilter
+// emits -- and checks matching against those rendered strings directly
+// against Evaluate's answer, instead of only ever comparing Evaluate to
+// itself (as TestEvaluateAgreesWithOptimize does). This is the kind of
+// cross-check that would have caught the \w-vs-Lua's-%w drift fixed in
+// regexToLuaPattern: %w excludes `_`, so a Lua-rendered "^\w+$" used to
+// silently disagree with Evaluate (and Fluent Bit's grep, which is backed
+// by the same regexp package Evaluate uses) on any value containing one.
+func TestRenderedOutputsAgreeWithEvaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		field  string
+		values []string
+		// checkFluentBit is only true for filters Optimize folds into a
+		// fieldMatch: that's the only shape whose Key_value_matches
+		// condition this package renders itself (fieldMatch.modifyComponent
+		// in optimize.go). A plain =~ ast.Restriction's Fluent Bit
+		// condition is rendered by the hidden ast package this request
+		// doesn't touch, so there's nothing of ours to cross-check there.
+		checkFluentBit bool
+	}{
+		{
+			name:           "same-field disjunction folds into Key_value_matches",
+			filter:         `severity = "ERROR" OR severity = "WARNING"`,
+			field:          "severity",
+			values:         []string{"ERROR", "WARNING", "INFO"},
+			checkFluentBit: true,
+		},
+		{
+			name:   "simple regex translates to a Lua string pattern",
+			filter: `severity =~ "^ERR.*"`,
+			field:  "severity",
+			values: []string{"ERROR", "INFO"},
+		},
+		{
+			name:   "word class regex falls back to the Lua regex table",
+			filter: `jsonPayload.container.name =~ "^\w+$"`,
+			field:  "jsonPayload.container.name",
+			values: []string{"foo_bar", "foo bar"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, value := range tc.values {
+				record := nestedRecord(tc.field, value)
+
+				unoptimized, err := NewFilter(tc.filter)
+				if err != nil {
+					t.Fatalf("NewFilter(%q) = %v", tc.filter, err)
+				}
+				want, err := unoptimized.Evaluate(record)
+				if err != nil {
+					t.Fatalf("Evaluate() = %v", err)
+				}
+
+				if tc.checkFluentBit {
+					fluentBitFilter, err := NewFilter(tc.filter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					fluentBitFilter.Optimize()
+					gotFluentBit, ok := evalKeyValueMatches(fluentBitFilter.Components("test.tag", false), value)
+					if !ok {
+						t.Fatalf("no Key_value_matches condition found for %q", tc.filter)
+					}
+					if gotFluentBit != want {
+						t.Errorf("rendered Key_value_matches regex disagrees with Evaluate for %q: got %v, want %v", value, gotFluentBit, want)
+					}
+				}
+
+				luaFilter, err := NewFilter(tc.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, script, err := NewLuaFilter("test.tag", []*Filter{luaFilter}, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotLua, ok := evalLuaScript(t, script, value)
+				if !ok {
+					t.Fatalf("could not extract a matchable condition from script:\n%s", script)
+				}
+				if gotLua != want {
+					t.Errorf("rendered Lua script disagrees with Evaluate for %q: got %v, want %v", value, gotLua, want)
+				}
+			}
+		})
+	}
+}
+
+// nestedRecord builds the record a dotted field path like
+// "jsonPayload.container.name" would read value back out of.
+func nestedRecord(path, value string) map[string]interface{} {
+	parts := strings.Split(path, ".")
+	var leaf interface{} = value
+	for i := len(parts) - 1; i > 0; i-- {
+		leaf = map[string]interface{}{parts[i]: leaf}
+	}
+	return map[string]interface{}{parts[0]: leaf}
+}
+
+// evalKeyValueMatches finds the Key_value_matches condition Components
+// rendered and reports whether its regex matches value, so the test
+// exercises the actual Fluent Bit config string instead of re-deriving
+// fieldMatch.regex() by hand.
+func evalKeyValueMatches(components []fluentbit.Component, value string) (matched, found bool) {
+	for _, c := range components {
+		cond, ok := c.Config["Condition"]
+		if !ok || !strings.HasPrefix(cond, "Key_value_matches ") {
+			continue
+		}
+		parts := strings.SplitN(cond, " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		return regexp.MustCompile(parts[2]).MatchString(value), true
+	}
+	return false, false
+}
+
+var (
+	luaEqualityRE    = regexp.MustCompile(`== "((?:[^"\\]|\\.)*)"\)`)
+	luaStringMatchRE = regexp.MustCompile(`string\.match\([^,]*, "((?:[^"\\]|\\.)*)"\) ~= nil`)
+	luaRexNewRE      = regexp.MustCompile(`rex\.new\("((?:[^"\\]|\\.)*)"\)`)
+)
+
+// evalLuaScript extracts every match condition NewLuaFilter rendered into
+// script (equality literals, direct Lua string patterns, and lrexlib
+// regex-table entries) and reports whether any of them matches value --
+// mirroring the "or" the generated filter_record function itself applies
+// across a disjunction's terms and a fieldMatch's patterns (see
+// luaGen.join and luaGen.fieldMatch).
+func evalLuaScript(t *testing.T, script, value string) (matched, found bool) {
+	t.Helper()
+	unquote := func(raw string) string {
+		s, err := strconv.Unquote(`"` + raw + `"`)
+		if err != nil {
+			t.Fatalf("unquoting %q: %v", raw, err)
+		}
+		return s
+	}
+	for _, m := range luaEqualityRE.FindAllStringSubmatch(script, -1) {
+		found = true
+		if unquote(m[1]) == value {
+			matched = true
+		}
+	}
+	for _, m := range luaRexNewRE.FindAllStringSubmatch(script, -1) {
+		found = true
+		if regexp.MustCompile(unquote(m[1])).MatchString(value) {
+			matched = true
+		}
+	}
+	for _, m := range luaStringMatchRE.FindAllStringSubmatch(script, -1) {
+		found = true
+		if regexp.MustCompile(luaPatternToRegex(unquote(m[1]))).MatchString(value) {
+			matched = true
+		}
+	}
+	return matched, found
+}
+
+// luaPatternToRegex translates a Lua string pattern back into the Go regexp
+// syntax it's a restricted subset of, for test purposes only -- this is the
+// inverse of globToLuaPattern/regexToLuaPattern, covering just the pattern
+// shapes this package actually emits (see lua_regex.go).
+func luaPatternToRegex(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c == '%' && i+1 < len(pattern) {
+			i++
+			switch next := pattern[i]; next {
+			case 'd':
+				b.WriteString(`[0-9]`)
+			case 's':
+				b.WriteString(`[ \t\n\r\f\v]`)
+			default:
+				b.WriteString(regexp.QuoteMeta(string(next)))
+			}
+			continue
+		}
+		switch c {
+		case '.', '*', '+', '?', '^', '$':
+			b.WriteByte(c)
+		case '-':
+			b.WriteString("*?")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
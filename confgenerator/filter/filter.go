@@ -36,7 +36,7 @@ func NewMember(m string) (*Member, error) {
 	p := parser.NewParser()
 	out, err := p.Parse(lex)
 	if err != nil {
-		return nil, err
+		return nil, wrapParseError(m, err)
 	}
 	r, ok := out.(ast.Restriction)
 	if !ok || r.Operator != "GLOBAL" {
@@ -56,7 +56,7 @@ func NewFilter(f string) (*Filter, error) {
 	p := parser.NewParser()
 	out, err := p.Parse(lex)
 	if err != nil {
-		return nil, err
+		return nil, wrapParseError(f, err)
 	}
 	if out, ok := out.(ast.Expression); ok {
 		return &Filter{out}, nil
@@ -144,15 +144,20 @@ func (f *Filter) Components(tag string, isExclusionFilter bool) []fluentbit.Comp
 // As an optimization, only a single set of nest/grep/lift components is
 // emitted in total.
 func AllComponents(tag string, filters []*Filter, isExclusionFilter bool) []fluentbit.Component {
+	if EnableLuaFilters {
+		if component, _, err := NewLuaFilter(tag, filters, isExclusionFilter); err == nil {
+			return []fluentbit.Component{component}
+		}
+		// Fall through to the legacy pipeline below; NewLuaFilter only fails
+		// for an ast.Expression type or a restriction operator it doesn't
+		// recognize.
+	}
 	var parity string
 	if isExclusionFilter {
 		parity = "Exclude"
 	} else {
 		parity = "Regex"
 	}
-	// TODO: Re-implement using Lua once regex is supported. Lua has been shown to perform better
-	// than the next/modify/grep/lift pattern used here, but we are unable to use Lua for now since
-	// it does not yet support regex.
 	c := []fluentbit.Component{{
 		Kind: "FILTER",
 		Config: map[string]string{
@@ -0,0 +1,228 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/filter/internal/ast"
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/fluentbit"
+)
+
+// EnableLuaFilters switches AllComponents over to the single-script Lua
+// pipeline built by NewLuaFilter instead of the legacy nest/modify/grep/lift
+// pipeline. It defaults to false so existing configs keep generating the
+// pipeline they were validated against; flip it once the Lua backend has
+// baked for a release.
+//
+// TODO(b/filter-lua-rollout): delete this flag, and the legacy pipeline in
+// AllComponents, once the Lua backend is the only backend.
+var EnableLuaFilters = false
+
+// luaCallName returns a Lua identifier derived from tag that is safe to use
+// as the "call" target of a FILTER lua component.
+func luaCallName(tag string) string {
+	return "filter_record_" + strings.NewReplacer(".", "_", "-", "_", "*", "_").Replace(tag)
+}
+
+// luaAccessor returns the Lua expression reading target out of the record
+// table, e.g. `record["jsonPayload"]["container"]["name"]`.
+func luaAccessor(target ast.Target) string {
+	var b strings.Builder
+	b.WriteString("record")
+	for _, part := range []string(target) {
+		b.WriteByte('[')
+		b.WriteString(LuaQuote(part))
+		b.WriteByte(']')
+	}
+	return b.String()
+}
+
+// luaGen walks an ast.Expression and emits the equivalent Lua boolean
+// expression, entirely in terms of the record table -- no Fluent Bit
+// components required. regexTable accumulates the patterns that can't be
+// expressed as a Lua string pattern (alternation, groups, bounded
+// quantifiers, ...); those get matched at runtime against a pre-registered
+// table of compiled lrexlib expressions instead.
+type luaGen struct {
+	regexTable []string
+}
+
+func (g *luaGen) expr(e ast.Expression) (string, error) {
+	switch e := e.(type) {
+	case ast.Disjunction:
+		return g.join(e, " or ")
+	case ast.Conjunction:
+		return g.join(e, " and ")
+	case ast.Negation:
+		inner, err := g.expr(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(not %s)", inner), nil
+	case ast.Restriction:
+		return g.restriction(e)
+	case fieldMatch:
+		return g.fieldMatch(e), nil
+	default:
+		return "", fmt.Errorf("filter: NewLuaFilter does not support expression type %T", e)
+	}
+}
+
+func (g *luaGen) join(terms []ast.Expression, op string) (string, error) {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		p, err := g.expr(t)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = p
+	}
+	return "(" + strings.Join(parts, op) + ")", nil
+}
+
+func (g *luaGen) fieldMatch(fm fieldMatch) string {
+	accessor := luaAccessor(fm.field)
+	conds := make([]string, len(fm.patterns))
+	for i, p := range fm.patterns {
+		if fm.literal {
+			conds[i] = fmt.Sprintf("(%s == %s)", accessor, LuaQuote(p))
+			continue
+		}
+		if pattern, ok := globToLuaPattern(p); ok {
+			conds[i] = fmt.Sprintf("(string.match(tostring(%s or \"\"), %s) ~= nil)", accessor, LuaQuote(pattern))
+			continue
+		}
+		conds[i] = g.regexMatch(accessor, "^("+globToRegex(p)+")$")
+	}
+	return "(" + strings.Join(conds, " or ") + ")"
+}
+
+func (g *luaGen) restriction(r ast.Restriction) (string, error) {
+	accessor := luaAccessor(r.LHS)
+	if r.Operator == "GLOBAL" {
+		return fmt.Sprintf("(%s ~= nil)", accessor), nil
+	}
+	rhs := fmt.Sprintf("%v", r.RHS)
+	switch r.Operator {
+	case "=", "!=":
+		var cond string
+		if !strings.ContainsAny(rhs, globMetacharacters) {
+			cond = fmt.Sprintf("(%s == %s)", accessor, LuaQuote(rhs))
+		} else if pattern, ok := globToLuaPattern(rhs); ok {
+			cond = fmt.Sprintf("(string.match(tostring(%s or \"\"), %s) ~= nil)", accessor, LuaQuote(pattern))
+		} else {
+			cond = g.regexMatch(accessor, "^("+globToRegex(rhs)+")$")
+		}
+		if r.Operator == "!=" {
+			cond = fmt.Sprintf("(not %s)", cond)
+		}
+		return cond, nil
+	case ":":
+		return fmt.Sprintf("(string.find(tostring(%s or \"\"), %s, 1, true) ~= nil)", accessor, LuaQuote(rhs)), nil
+	case "=~", "!~":
+		var cond string
+		if pattern, ok := regexToLuaPattern(rhs); ok {
+			cond = fmt.Sprintf("(string.match(tostring(%s or \"\"), %s) ~= nil)", accessor, LuaQuote(pattern))
+		} else {
+			cond = g.regexMatch(accessor, rhs)
+		}
+		if r.Operator == "!~" {
+			cond = fmt.Sprintf("(not %s)", cond)
+		}
+		return cond, nil
+	case "<", ">", "<=", ">=":
+		return fmt.Sprintf("(tonumber(%s) %s tonumber(%s))", accessor, r.Operator, LuaQuote(rhs)), nil
+	default:
+		return "", fmt.Errorf("filter: NewLuaFilter does not support operator %q", r.Operator)
+	}
+}
+
+// regexMatch registers re in g.regexTable (a compiled-regex fallback table,
+// built once per script via lrexlib's rex.new) and returns the Lua
+// expression that matches accessor against it.
+func (g *luaGen) regexMatch(accessor, re string) string {
+	g.regexTable = append(g.regexTable, re)
+	idx := len(g.regexTable)
+	return fmt.Sprintf("(__filter_regex[%d]:match(tostring(%s or \"\")) ~= nil)", idx, accessor)
+}
+
+// NewLuaFilter returns a single FILTER lua component implementing filters as
+// one generated filter_record function, plus the Lua source used for that
+// component's Script config. isExclusionFilter selects whether a match
+// drops the record (exclusion filters) or lets it through (inclusion
+// filters).
+//
+// Unlike innerFluentConfig, the script generated here never depends on any
+// Fluent Bit component: every comparison, including =~/!~, is expressed in
+// Lua. Regexes that reduce to a plain Lua string pattern (the common case:
+// literal runs, ., *, ^, $, \d/\s) are translated and matched with the
+// dependency-free string.match. Regexes that need true regex features --
+// alternation, groups, bounded repetition, \w, or `[...]` classes -- are
+// matched via a compiled-regex table built with lrexlib's rex.new, which
+// Fluent Bit must be built with the "rex" module available to use. \w is
+// routed to the regex table rather than Lua's %w: see regexToLuaPattern's
+// doc comment for why they aren't equivalent.
+func NewLuaFilter(tag string, filters []*Filter, isExclusionFilter bool) (fluentbit.Component, string, error) {
+	call := luaCallName(tag)
+	g := &luaGen{}
+	var body strings.Builder
+	var matchVars []string
+	for i, f := range filters {
+		expr, err := g.expr(f.expr)
+		if err != nil {
+			return fluentbit.Component{}, "", fmt.Errorf("filter %d for tag %q: %w", i, tag, err)
+		}
+		v := fmt.Sprintf("matched%d", i)
+		fmt.Fprintf(&body, "  local %s = %s\n", v, expr)
+		matchVars = append(matchVars, v)
+	}
+	matched := strings.Join(matchVars, " or ")
+	if matched == "" {
+		matched = "false"
+	}
+	if isExclusionFilter {
+		matched = fmt.Sprintf("(%s)", matched)
+	} else {
+		matched = fmt.Sprintf("not (%s)", matched)
+	}
+
+	var preamble strings.Builder
+	if len(g.regexTable) > 0 {
+		preamble.WriteString("local __filter_regex = {}\n")
+		for i, re := range g.regexTable {
+			fmt.Fprintf(&preamble, "__filter_regex[%d] = rex.new(%s)\n", i+1, LuaQuote(re))
+		}
+	}
+
+	script := fmt.Sprintf(`%sfunction %s(tag, timestamp, record)
+%s  if %s then
+    return -1, timestamp, record
+  end
+  return 0, timestamp, record
+end
+`, preamble.String(), call, body.String(), matched)
+
+	return fluentbit.Component{
+		Kind: "FILTER",
+		Config: map[string]string{
+			"Name":   "lua",
+			"Match":  tag,
+			"call":   call,
+			"Script": script,
+		},
+	}, script, nil
+}
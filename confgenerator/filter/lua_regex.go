@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import "strings"
+
+// luaMagicChars are the characters Lua patterns treat specially; a literal
+// occurrence must be escaped with '%'.
+const luaMagicChars = "().%+-*?[]^$"
+
+// globToLuaPattern translates a telegraf-style glob (`*`, `?`, plain
+// literals) into a Lua string pattern anchored at both ends, so that it
+// matches the whole value as the glob does, or ok=false if glob contains a `[...]`
+// character class, which isn't worth reconciling against Lua's slightly
+// different bracket-class syntax -- the caller falls back to the
+// lrexlib-backed regex table for those.
+func globToLuaPattern(glob string) (string, bool) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[', ']':
+			return "", false
+		default:
+			if strings.ContainsRune(luaMagicChars, r) {
+				b.WriteByte('%')
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('$')
+	return b.String(), true
+}
+
+// regexToLuaPattern translates the conservative subset of regex syntax that
+// maps directly onto Lua string patterns: literals (a literal `-` is escaped
+// to `%-`, since a bare `-` is Lua's non-greedy `*`), `.`, `*`, `+`, `?`,
+// `^`, `$`, and the `\d`/`\s` classes (mapped to
+// Lua's `%d`/`%s`). It returns ok=false for anything needing a real regex
+// engine -- alternation (`|`), groups (`(`, `)`), bounded repetition
+// (`{m,n}`), `[...]` classes, and `\w` -- so the caller can fall back to the
+// pre-registered lrexlib regex table instead of silently mistranslating.
+//
+// `\w` is deliberately NOT mapped to Lua's `%w`: Go/PCRE `\w` is
+// `[0-9A-Za-z_]` but Lua's `%w` is letters+digits only and has no notion of
+// a custom character set to add `_` to, so `"^\w+$"` against "foo_bar" would
+// match under every other backend and silently fail to match here. Routing
+// it through the regex table keeps the Lua backend's behavior identical to
+// Evaluate and the legacy grep pipeline instead of drifting on the single
+// most common case (identifiers/resource names containing `_`).
+func regexToLuaPattern(re string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(re); i++ {
+		c := re[i]
+		switch c {
+		case '.', '*', '+', '?', '^', '$':
+			b.WriteByte(c)
+		case '(', ')', '|', '{', '}', '[', ']':
+			return "", false
+		case '\\':
+			if i+1 >= len(re) {
+				return "", false
+			}
+			i++
+			switch next := re[i]; next {
+			case 'd':
+				b.WriteString("%d")
+			case 's':
+				b.WriteString("%s")
+			case 'w':
+				return "", false
+			case '.', '*', '+', '?', '^', '$', '(', ')', '[', ']', '\\', '|', '{', '}':
+				b.WriteByte('%')
+				b.WriteByte(next)
+			default:
+				return "", false
+			}
+		case '%':
+			b.WriteString("%%")
+		default:
+			if strings.ContainsRune("-", rune(c)) {
+				b.WriteByte('%')
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/lua")
+
+func TestNewLuaFilterGolden(t *testing.T) {
+	tests := []struct {
+		name              string
+		filters           []string
+		isExclusionFilter bool
+	}{
+		{
+			name:    "single_equality",
+			filters: []string{`severity = "ERROR"`},
+		},
+		{
+			name:    "disjunction",
+			filters: []string{`severity = "ERROR" OR severity = "WARNING"`},
+		},
+		{
+			name:              "exclusion",
+			filters:           []string{`severity = "DEBUG"`},
+			isExclusionFilter: true,
+		},
+		{
+			name:    "multiple_filters",
+			filters: []string{`severity = "ERROR"`, `jsonPayload.container.name = "foo"`},
+		},
+		{
+			// A regex simple enough to translate directly into a Lua string
+			// pattern: no lrexlib dependency needed.
+			name:    "simple_regex",
+			filters: []string{`severity =~ "^ERR.*"`},
+		},
+		{
+			// Alternation isn't representable as a Lua pattern, so this
+			// exercises the pre-registered compiled-regex (lrexlib) fallback.
+			name:    "complex_regex",
+			filters: []string{`jsonPayload.container.name =~ "^foo(bar|baz)$"`},
+		},
+		{
+			// \w is not Lua's %w (which excludes `_`), so it must fall back
+			// to the regex table rather than mistranslate -- see
+			// regexToLuaPattern's doc comment.
+			name:    "word_class_regex",
+			filters: []string{`jsonPayload.container.name =~ "^\w+$"`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var filters []*Filter
+			for _, f := range tc.filters {
+				filter, err := NewFilter(f)
+				if err != nil {
+					t.Fatalf("NewFilter(%q) = %v", f, err)
+				}
+				filters = append(filters, filter)
+			}
+			_, script, err := NewLuaFilter("test.tag", filters, tc.isExclusionFilter)
+			if err != nil {
+				t.Fatalf("NewLuaFilter() = %v", err)
+			}
+			golden := filepath.Join("testdata", "lua", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(script), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if script != string(want) {
+				t.Errorf("NewLuaFilter() script mismatch; got:\n%s\nwant:\n%s\n(run with -update to regenerate)", script, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/filter/internal/ast"
+	"github.com/GoogleCloudPlatform/ops-agent/confgenerator/fluentbit"
+)
+
+// globMetacharacters are the characters telegraf-style glob filters treat
+// specially. A pattern containing none of them is a plain string and can be
+// matched with a map lookup instead of a compiled pattern.
+const globMetacharacters = "*?["
+
+// fieldMatch is the result of recognizing a disjunction of equality/glob
+// comparisons against a single field, e.g.
+// `severity = "ERROR" OR severity = "WARNING"`. It implements
+// ast.Expression so Optimize can drop it in place of the original
+// ast.Disjunction; FluentConfig/Components emit a single modify component
+// whose Key_value_matches condition is one combined alternation, instead of
+// one modify per branch. They do not emit a grep component of their own.
+//
+// Only `=` is folded this way. `:` ("has") isn't glob/equality comparison in
+// the Cloud Logging filter language -- it's a substring/contains test -- so
+// folding it into the same glob-alternation path as `=` would silently
+// change its semantics; evaluateRestriction's `:` case stays a plain
+// ast.Restriction and is never rewritten into a fieldMatch.
+type fieldMatch struct {
+	field      ast.Target
+	patterns   []string
+	literal    bool                // true if no pattern contains a glob metacharacter
+	literalSet map[string]struct{} // populated iff literal; the map lookup fast path
+}
+
+// optimizeDisjunction recognizes a disjunction of `field = "pattern"`
+// restrictions (literal or glob patterns) that all target the same field
+// and returns the equivalent
+// fieldMatch, or ok=false if e isn't in that shape.
+func optimizeDisjunction(e ast.Expression) (fieldMatch, bool) {
+	d, ok := e.(ast.Disjunction)
+	if !ok || len(d) < 2 {
+		return fieldMatch{}, false
+	}
+	fm := fieldMatch{literal: true}
+	var field string
+	for _, term := range d {
+		r, ok := term.(ast.Restriction)
+		if !ok || r.Operator != "=" {
+			return fieldMatch{}, false
+		}
+		termField := fmt.Sprintf("%v", r.LHS)
+		if field == "" {
+			field = termField
+			fm.field = r.LHS
+		} else if field != termField {
+			return fieldMatch{}, false
+		}
+		pattern := fmt.Sprintf("%v", r.RHS)
+		if strings.ContainsAny(pattern, globMetacharacters) {
+			fm.literal = false
+		}
+		fm.patterns = append(fm.patterns, pattern)
+	}
+	if fm.literal {
+		fm.literalSet = make(map[string]struct{}, len(fm.patterns))
+		for _, p := range fm.patterns {
+			fm.literalSet[p] = struct{}{}
+		}
+	}
+	return fm, true
+}
+
+// Optimize rewrites f in place, lowering every disjunction of equality/glob
+// comparisons on the same field (the common ops-agent case of
+// `severity = "ERROR" OR severity = "WARNING"`) into a single combined
+// match, instead of the one modify component per branch that Components
+// would otherwise emit. It recurses into ast.Conjunction and ast.Negation
+// children, so a same-field disjunction folds wherever it appears in the
+// tree -- including the realistic
+// `(severity = "ERROR" OR severity = "WARNING") AND region = "us"` shape,
+// not just when the whole filter is one disjunction. Subtrees that aren't
+// in that shape are left untouched. Optimize is idempotent and safe to call
+// on any filter.
+func (f *Filter) Optimize() {
+	f.expr, _ = optimizeExpr(f.expr)
+}
+
+// optimizeExpr rewrites e and reports whether it changed anything,
+// recursing into ast.Disjunction/ast.Conjunction terms and ast.Negation's
+// child looking for a same-field disjunction optimizeDisjunction can fold.
+// It returns e itself (changed=false), not the copy it builds while
+// recursing, when nothing in the subtree was foldable, so Optimize leaves
+// filters that don't have this shape anywhere structurally unchanged.
+func optimizeExpr(e ast.Expression) (ast.Expression, bool) {
+	switch e := e.(type) {
+	case ast.Disjunction:
+		if fm, ok := optimizeDisjunction(e); ok {
+			return fm, true
+		}
+		out := make(ast.Disjunction, len(e))
+		changed := false
+		for i, term := range e {
+			rewritten, ok := optimizeExpr(term)
+			out[i] = rewritten
+			changed = changed || ok
+		}
+		if !changed {
+			return e, false
+		}
+		return out, true
+	case ast.Conjunction:
+		out := make(ast.Conjunction, len(e))
+		changed := false
+		for i, term := range e {
+			rewritten, ok := optimizeExpr(term)
+			out[i] = rewritten
+			changed = changed || ok
+		}
+		if !changed {
+			return e, false
+		}
+		return out, true
+	case ast.Negation:
+		rewritten, ok := optimizeExpr(e.Expr)
+		if !ok {
+			return e, false
+		}
+		return ast.Negation{Expr: rewritten}, true
+	default:
+		return e, false
+	}
+}
+
+// globToRegex converts a telegraf-style glob (`*`, `?`, `[...]`) into the
+// equivalent POSIX extended regex fragment understood by Fluent Bit's grep
+// filter.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			if strings.ContainsRune(`\.+()|^$`, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// regex returns a single POSIX extended regex matching any of fm.patterns,
+// suitable for Fluent Bit's Key_value_matches condition. If fm.literal,
+// building the map lookup used by Evaluate (see evaluate.go) is cheaper, but
+// Fluent Bit components still need a single regex either way.
+func (fm fieldMatch) regex() string {
+	alternatives := make([]string, len(fm.patterns))
+	for i, p := range fm.patterns {
+		alternatives[i] = globToRegex(p)
+	}
+	return "^(" + strings.Join(alternatives, "|") + ")$"
+}
+
+// fieldPath renders an ast.Target as the dotted field path ("jsonPayload.
+// container.name") used in the modify component's Key_value_matches
+// condition. luaAccessor does not use it; it indexes the Target segment by
+// segment.
+func fieldPath(t ast.Target) string {
+	return strings.Join([]string(t), ".")
+}
+
+// modifyComponent returns the single modify component that sets key to 1
+// when the record's fm.field matches one of fm.patterns, replacing the one
+// modify-per-branch that a literal ast.Disjunction would otherwise emit.
+//
+// Fluent Bit's modify plugin only understands Key_value_matches (regex), so
+// this is the single code path regardless of fm.literal: the plugin has no
+// set-membership primitive to map a literalSet onto. fm.literalSet is used
+// by Evaluate (see below) instead, where an actual map lookup is possible.
+func (fm fieldMatch) modifyComponent(tag, key string) fluentbit.Component {
+	return fluentbit.Component{
+		Kind: "FILTER",
+		Config: map[string]string{
+			"Name":      "modify",
+			"Match":     tag,
+			"Condition": fmt.Sprintf("Key_value_matches %s %s", fieldPath(fm.field), fm.regex()),
+			"Set":       fmt.Sprintf("%s 1", key),
+		},
+	}
+}
+
+func (fm fieldMatch) FluentConfig(tag, prefix string) ([]fluentbit.Component, string) {
+	return []fluentbit.Component{fm.modifyComponent(tag, prefix)}, fmt.Sprintf("%s == 1", prefix)
+}
+
+func (fm fieldMatch) Components(tag, match string) []fluentbit.Component {
+	return []fluentbit.Component{fm.modifyComponent(tag, match)}
+}
+
+// Evaluate reports whether value matches one of fm.patterns, using an O(1)
+// map lookup when every pattern is a literal string (telegraf's filter.
+// Compile technique) and falling back to the same glob-to-regex translation
+// used by Components/FluentConfig otherwise.
+func (fm fieldMatch) Evaluate(value string) bool {
+	if fm.literal {
+		_, ok := fm.literalSet[value]
+		return ok
+	}
+	re, err := regexp.Compile(fm.regex())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// severityDisjunction returns a filter string matching n severities, e.g.
+// `severity = "L0" OR severity = "L1" OR ...`.
+func severityDisjunction(n int) string {
+	var terms []string
+	for i := 0; i < n; i++ {
+		terms = append(terms, fmt.Sprintf(`severity = "L%d"`, i))
+	}
+	return strings.Join(terms, " OR ")
+}
+
+func BenchmarkComponentsUnoptimized(b *testing.B) {
+	for _, n := range []int{2, 8, 32} {
+		n := n
+		b.Run(fmt.Sprintf("branches=%d", n), func(b *testing.B) {
+			f, err := NewFilter(severityDisjunction(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = f.Components("test.tag", false)
+			}
+		})
+	}
+}
+
+func BenchmarkComponentsOptimized(b *testing.B) {
+	for _, n := range []int{2, 8, 32} {
+		n := n
+		b.Run(fmt.Sprintf("branches=%d", n), func(b *testing.B) {
+			f, err := NewFilter(severityDisjunction(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f.Optimize()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = f.Components("test.tag", false)
+			}
+		})
+	}
+}
+
+func TestOptimizeReducesComponentCount(t *testing.T) {
+	f, err := NewFilter(severityDisjunction(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(f.Components("test.tag", false))
+	f.Optimize()
+	after := len(f.Components("test.tag", false))
+	if after >= before {
+		t.Errorf("Optimize() did not reduce component count: before=%d after=%d", before, after)
+	}
+}
+
+func TestOptimizeLeavesMixedFieldsAlone(t *testing.T) {
+	f, err := NewFilter(`severity = "ERROR" OR jsonPayload.container.name = "foo"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := f.Components("test.tag", false)
+	f.Optimize()
+	after := f.Components("test.tag", false)
+	if len(before) != len(after) {
+		t.Errorf("Optimize() changed a disjunction across different fields: before=%d after=%d", len(before), len(after))
+	}
+}
+
+func TestOptimizeReducesComponentCountForNestedField(t *testing.T) {
+	f, err := NewFilter(`jsonPayload.container.name = "foo*" OR jsonPayload.container.name = "bar*"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(f.Components("test.tag", false))
+	f.Optimize()
+	after := len(f.Components("test.tag", false))
+	if after >= before {
+		t.Errorf("Optimize() did not reduce component count for a nested field: before=%d after=%d", before, after)
+	}
+}
+
+func TestOptimizeFoldsDisjunctionNestedInConjunction(t *testing.T) {
+	f, err := NewFilter(`(severity = "ERROR" OR severity = "WARNING") AND region = "us"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(f.Components("test.tag", false))
+	f.Optimize()
+	after := len(f.Components("test.tag", false))
+	if after >= before {
+		t.Errorf("Optimize() did not fold a same-field disjunction nested in a conjunction: before=%d after=%d", before, after)
+	}
+}
+
+func TestOptimizeDoesNotFoldHasOperator(t *testing.T) {
+	f, err := NewFilter(`severity : "ERR" OR severity : "WARN"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(f.Components("test.tag", false))
+	f.Optimize()
+	after := len(f.Components("test.tag", false))
+	if after != before {
+		t.Errorf("Optimize() folded a `:` disjunction into a glob fieldMatch: before=%d after=%d", before, after)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzNewFilterNeverPanics exercises NewFilter (still gocc-backed -- see the
+// SCOPE note on ParseError) against arbitrary input and asserts it never
+// panics and that failures come back as *ParseError. It fuzzes exactly one
+// parser: gocc. It is NOT the side-by-side gocc-vs-ANTLR fuzz harness the
+// ANTLR migration backlog item asked for, and nothing here moves toward
+// that -- a second, ANTLR-backed parser to compare against does not exist
+// in this tree. Once internal/generated/antlr is generated and wired up and
+// NewFilter can be parameterized over a parser backend, extend this to
+// parse every input with both and fail on any semantic drift between the
+// resulting ast.Expressions.
+func FuzzNewFilterNeverPanics(f *testing.F) {
+	for _, seed := range []string{
+		`severity = "ERROR"`,
+		`severity = "ERROR" OR severity = "WARNING"`,
+		`jsonPayload.container.name =~ "foo.*"`,
+		`NOT severity = "DEBUG"`,
+		`(`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		_, err := NewFilter(input)
+		if err == nil {
+			return
+		}
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("NewFilter(%q) returned a non-ParseError error: %v (%T)", input, err, err)
+		}
+	})
+}
+
+func TestParseErrorFormatting(t *testing.T) {
+	err := &ParseError{Input: `severity = `, Line: 1, Column: 12, Message: "unexpected end of input"}
+	got := err.Error()
+	for _, want := range []string{"1:12", "unexpected end of input", "^"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ParseError.Error() = %q, want substring %q", got, want)
+		}
+	}
+}
+
+func TestParseErrorWithoutPosition(t *testing.T) {
+	err := &ParseError{Message: "no position available"}
+	if got := err.Error(); !strings.Contains(got, "no position available") {
+		t.Errorf("ParseError.Error() = %q, want message preserved", got)
+	}
+}